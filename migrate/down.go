@@ -0,0 +1,188 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+
+	"github.com/scylladb/gocqlx/v3"
+)
+
+// Down reverts the last steps applied migrations found in fsys, most
+// recently applied first. A migration can only be rolled back if it has
+// a paired down file; Down refuses to proceed otherwise. The down file's
+// checksum is compared against the one recorded when the migration was
+// applied, so a down file edited after the fact is reported the same way
+// a tampered up file is.
+func Down(ctx context.Context, session gocqlx.Session, fsys fs.FS, steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("migrate: steps must be positive, got %d", steps)
+	}
+
+	return withLock(ctx, session, func() error {
+		byVersion, err := entriesByVersion(fsys)
+		if err != nil {
+			return err
+		}
+
+		applied, err := appliedVersionsDesc(ctx, session)
+		if err != nil {
+			return err
+		}
+		if steps > len(applied) {
+			steps = len(applied)
+		}
+
+		for i := 0; i < steps; i++ {
+			a := applied[i]
+			e, ok := byVersion[a.Version]
+			if !ok || e.DownFile == "" {
+				return fmt.Errorf("migrate: no down migration found for version %d (%s)", a.Version, a.Name)
+			}
+			if err := applyDown(ctx, session, fsys, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func applyDown(ctx context.Context, session gocqlx.Session, fsys fs.FS, e entry) error {
+	recorded, err := downChecksum(ctx, session, e.Version)
+	if err != nil {
+		return err
+	}
+	sum, err := checksum(fsys, e.DownFile)
+	if err != nil {
+		return err
+	}
+	if recorded != nil && !bytes.Equal(sum, recorded) {
+		return fmt.Errorf("migrate: migration %s has been tampered with", e.DownFile)
+	}
+
+	if err := callback(ctx, session, BeforeRollback, e.Name); err != nil {
+		return err
+	}
+
+	if err := markDirty(ctx, session, e.Version, e.Name); err != nil {
+		return err
+	}
+
+	if err := execFile(ctx, session, fsys, e.DownFile); err != nil {
+		return err
+	}
+
+	stmt := `DELETE FROM ` + migrateTable + ` WHERE version = ?`
+	if err := session.Query(stmt, nil).WithContext(ctx).Bind(e.Version).Exec(); err != nil {
+		return err
+	}
+
+	return callback(ctx, session, AfterRollback, e.Name)
+}
+
+// downChecksum returns the down-file checksum recorded for version when it
+// was applied, or nil if none was recorded.
+func downChecksum(ctx context.Context, session gocqlx.Session, version int64) ([]byte, error) {
+	var sum []byte
+	q := session.Query(`SELECT down_checksum FROM `+migrateTable+` WHERE version = ?`, nil).WithContext(ctx).Bind(version)
+	if err := q.Get(&sum); err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
+// Goto migrates the database tracked by session up or down so that its
+// current version matches target, applying ups from fsys if target is
+// ahead of the current version or downs if it is behind.
+func Goto(ctx context.Context, session gocqlx.Session, fsys fs.FS, target int) error {
+	if err := ensureTable(ctx, session); err != nil {
+		return err
+	}
+
+	current, err := currentVersion(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	switch want := int64(target); {
+	case want > current:
+		return FromSource(ctx, session, boundedSource{Source: NewFSSource(fsys), max: want})
+	case want < current:
+		steps, err := appliedAboveCount(ctx, session, want)
+		if err != nil {
+			return err
+		}
+		return Down(ctx, session, fsys, steps)
+	default:
+		return nil
+	}
+}
+
+// appliedAboveCount returns the number of applied migrations with a
+// version strictly greater than target, the number of steps Down must
+// take to bring the database back down to target. Down's steps counts
+// applied migrations, not a version delta, so this does not simply
+// reduce to a subtraction when applied versions have gaps.
+func appliedAboveCount(ctx context.Context, session gocqlx.Session, target int64) (int, error) {
+	applied, err := appliedVersionsDesc(ctx, session)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, a := range applied {
+		if a.Version > target {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// boundedSource wraps src, hiding every entry past max, so that Goto can
+// apply ups up to a target version without also running migrations
+// beyond it.
+type boundedSource struct {
+	Source
+	max int64
+}
+
+// List implements Source.
+func (s boundedSource) List() ([]Entry, error) {
+	entries, err := s.Source.List()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if e.Version <= s.max {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// downChecksumFor forwards to the wrapped Source when it supports
+// recording down-file checksums, so Goto's up-path keeps the same
+// tamper-check coverage as FromFS.
+func (s boundedSource) downChecksumFor(name string) ([]byte, bool, error) {
+	if dcs, ok := s.Source.(downChecksumSource); ok {
+		return dcs.downChecksumFor(name)
+	}
+	return nil, false, nil
+}
+
+func entriesByVersion(fsys fs.FS) (map[int64]entry, error) {
+	entries, err := listEntries(fsys)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]entry, len(entries))
+	for _, e := range entries {
+		byVersion[e.Version] = e
+	}
+	return byVersion, nil
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+//go:build all || integration
+// +build all integration
+
+package migrate_test
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/psanford/memfs"
+
+	"github.com/scylladb/gocqlx/v3/gocqlxtest"
+	"github.com/scylladb/gocqlx/v3/migrate"
+)
+
+func writeUpDown(f *memfs.FS, version int, up, down string) {
+	f.WriteFile(fmt.Sprintf("%04d_test.up.cql", version), []byte(up), fs.ModePerm)
+	f.WriteFile(fmt.Sprintf("%04d_test.down.cql", version), []byte(down), fs.ModePerm)
+}
+
+func TestDownAndGoto(t *testing.T) {
+	session := gocqlxtest.CreateSession(t)
+	defer session.Close()
+	recreateTables(t, session)
+
+	if err := session.ExecStmt(migrateSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	f := memfs.New()
+	for i := 1; i <= 3; i++ {
+		writeUpDown(f, i,
+			fmt.Sprintf(insertMigrate, i)+";",
+			fmt.Sprintf("DELETE FROM gocqlx_test.migrate_table WHERE testint = %d;", i))
+	}
+
+	t.Run("apply three", func(t *testing.T) {
+		if err := migrate.FromFS(ctx, session, f); err != nil {
+			t.Fatal(err)
+		}
+		if c := countMigrations(t, session); c != 3 {
+			t.Fatal("expected 3 migrations got", c)
+		}
+	})
+
+	t.Run("roll back two", func(t *testing.T) {
+		if err := migrate.Down(ctx, session, f, 2); err != nil {
+			t.Fatal(err)
+		}
+		if c := countMigrations(t, session); c != 1 {
+			t.Fatal("expected 1 migration left got", c)
+		}
+	})
+
+	t.Run("re-apply one via goto", func(t *testing.T) {
+		if err := migrate.Goto(ctx, session, f, 2); err != nil {
+			t.Fatal(err)
+		}
+		if c := countMigrations(t, session); c != 2 {
+			t.Fatal("expected 2 migrations got", c)
+		}
+	})
+
+	t.Run("tamper check on down file", func(t *testing.T) {
+		writeUpDown(f, 2,
+			fmt.Sprintf(insertMigrate, 2)+";",
+			"SELECT * FROM bla;")
+
+		if err := migrate.Down(ctx, session, f, 1); err == nil || !strings.Contains(err.Error(), "tampered") {
+			t.Fatal("expected tampered error, got", err)
+		}
+	})
+}
+
+// TestGotoDownWithGaps ensures Goto's down path counts applied migrations
+// above target, rather than the version delta, which only coincide for
+// gap-free sequential versions.
+func TestGotoDownWithGaps(t *testing.T) {
+	session := gocqlxtest.CreateSession(t)
+	defer session.Close()
+	recreateTables(t, session)
+
+	if err := session.ExecStmt(migrateSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	f := memfs.New()
+	for _, v := range []int{10, 20, 30} {
+		writeUpDown(f, v,
+			fmt.Sprintf(insertMigrate, v)+";",
+			fmt.Sprintf("DELETE FROM gocqlx_test.migrate_table WHERE testint = %d;", v))
+	}
+
+	if err := migrate.FromFS(ctx, session, f); err != nil {
+		t.Fatal(err)
+	}
+	if c := countMigrations(t, session); c != 3 {
+		t.Fatal("expected 3 migrations got", c)
+	}
+
+	// current - target = 20, which must not be used as the step count:
+	// only versions 20 and 30 are above target 10, so Down must take
+	// exactly 2 steps and leave version 10 applied.
+	if err := migrate.Goto(ctx, session, f, 10); err != nil {
+		t.Fatal(err)
+	}
+	if c := countMigrations(t, session); c != 1 {
+		t.Fatal("expected 1 migration left got", c)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v3"
+)
+
+// LockTimeout is the maximum time FromFS, Down and Goto wait to acquire
+// the migration lock before giving up with ErrLocked.
+var LockTimeout = 30 * time.Second
+
+// LockRetryInterval is the time between successive attempts to acquire
+// the migration lock.
+var LockRetryInterval = 500 * time.Millisecond
+
+// ErrLocked is returned when the migration lock is held by another
+// process and could not be acquired before LockTimeout elapsed.
+var ErrLocked = errors.New("migrate: migrations are locked by another process")
+
+// ErrDirty is returned when the tracking table records a migration that
+// failed partway through a previous run, leaving the schema in an
+// unknown state. Inspect the schema, repair it if needed, then call
+// Force to clear the dirty flag before migrating again.
+var ErrDirty = errors.New("migrate: database is dirty, run Force to clear it")
+
+// withLock acquires the migration lock, runs fn while holding it, and
+// releases it on return. It also refuses to run fn if the tracking table
+// is marked dirty.
+func withLock(ctx context.Context, session gocqlx.Session, fn func() error) error {
+	if err := ensureTable(ctx, session); err != nil {
+		return err
+	}
+
+	owner, err := acquireLock(ctx, session)
+	if err != nil {
+		return err
+	}
+	defer releaseLock(context.Background(), session, owner)
+
+	if err := checkNotDirty(ctx, session); err != nil {
+		return err
+	}
+	return fn()
+}
+
+func acquireLock(ctx context.Context, session gocqlx.Session) (gocql.UUID, error) {
+	owner, err := gocql.RandomUUID()
+	if err != nil {
+		return gocql.UUID{}, err
+	}
+
+	deadline := time.Now().Add(LockTimeout)
+	stmt := `INSERT INTO ` + migrateTable + ` (version, owner, acquired_at) VALUES (?, ?, toTimestamp(now())) IF NOT EXISTS`
+
+	for {
+		// A failed "IF NOT EXISTS" returns the whole existing row, not
+		// just the columns bound above, so MapScanCAS is used instead of
+		// ScanCAS, which requires the scan targets to match it exactly.
+		q := session.Query(stmt, nil).WithContext(ctx).Bind(lockVersion, owner)
+		applied, err := q.MapScanCAS(make(map[string]interface{}))
+		if err != nil {
+			return gocql.UUID{}, err
+		}
+		if applied {
+			return owner, nil
+		}
+
+		if time.Now().After(deadline) {
+			return gocql.UUID{}, ErrLocked
+		}
+
+		select {
+		case <-ctx.Done():
+			return gocql.UUID{}, ctx.Err()
+		case <-time.After(LockRetryInterval):
+		}
+	}
+}
+
+func releaseLock(ctx context.Context, session gocqlx.Session, owner gocql.UUID) error {
+	stmt := `DELETE FROM ` + migrateTable + ` WHERE version = ? IF owner = ?`
+	return session.Query(stmt, nil).WithContext(ctx).Bind(lockVersion, owner).Exec()
+}
+
+// markDirty records that version is being applied or rolled back, before
+// its statements run. Once the statements complete successfully the row
+// is overwritten with dirty = false by an up migration, or deleted by a
+// down migration, so a row left with dirty = true after a run means that
+// run was interrupted mid-file.
+func markDirty(ctx context.Context, session gocqlx.Session, version int64, name string) error {
+	stmt := `INSERT INTO ` + migrateTable + ` (version, name, dirty, timestamp) VALUES (?, ?, true, toTimestamp(now()))`
+	return session.Query(stmt, nil).WithContext(ctx).Bind(version, name).Exec()
+}
+
+// pauseReasonCancelled marks a dirty row left behind by a clean context
+// cancellation between statements, rather than a statement failure. Such
+// rows have a valid checkpoint and are safe to resume automatically,
+// unlike other dirty rows which require Force.
+const pauseReasonCancelled = "cancelled"
+
+func checkNotDirty(ctx context.Context, session gocqlx.Session) error {
+	stmt := `SELECT version, pause_reason FROM ` + migrateTable + ` WHERE dirty = true ALLOW FILTERING`
+	iter := session.Query(stmt, nil).WithContext(ctx).Iter()
+
+	var (
+		version     int64
+		pauseReason string
+	)
+	scanner := iter.Scanner()
+	for scanner.Next() {
+		if err := scanner.Scan(&version, &pauseReason); err != nil {
+			return err
+		}
+		if pauseReason == pauseReasonCancelled {
+			continue
+		}
+		return fmt.Errorf("%w: version %d", ErrDirty, version)
+	}
+	return scanner.Err()
+}
+
+// Force clears the dirty flag on the tracking row for version, creating
+// the row if it does not exist, so that version counts as applied,
+// without executing any statements. Use it once the schema has been
+// manually verified or repaired after a migration failed partway through.
+// Only the row for version is changed: dirty rows for other versions and
+// migrations applied above version are left as they are.
+func Force(ctx context.Context, session gocqlx.Session, version int) error {
+	if err := ensureTable(ctx, session); err != nil {
+		return err
+	}
+
+	stmt := `UPDATE ` + migrateTable + ` SET dirty = false WHERE version = ?`
+	return session.Query(stmt, nil).WithContext(ctx).Bind(int64(version)).Exec()
+}
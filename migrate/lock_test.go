@@ -0,0 +1,146 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+//go:build all || integration
+// +build all integration
+
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/psanford/memfs"
+
+	"github.com/scylladb/gocqlx/v3"
+	"github.com/scylladb/gocqlx/v3/gocqlxtest"
+	"github.com/scylladb/gocqlx/v3/migrate"
+)
+
+func TestConcurrentFromFS(t *testing.T) {
+	session := gocqlxtest.CreateSession(t)
+	defer session.Close()
+	recreateTables(t, session)
+
+	if err := session.ExecStmt(migrateSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	f := makeTestFS(5)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = migrate.FromFS(ctx, session, f)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal("expected both racing calls to succeed, got", err)
+		}
+	}
+	if c := countMigrations(t, session); c != 5 {
+		t.Fatal("expected 5 migrations got", c)
+	}
+}
+
+func TestDirtyAndForce(t *testing.T) {
+	session := gocqlxtest.CreateSession(t)
+	defer session.Close()
+	recreateTables(t, session)
+
+	if err := session.ExecStmt(migrateSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	f := memfs.New()
+	writeFile(f, 0, fmt.Sprintf(insertMigrate, 0)+";")
+	// Version 1 fails partway through a multi-statement file.
+	f.WriteFile("1.cql", []byte(fmt.Sprintf(insertMigrate, 1)+"; SELECT * FROM bla;"), fs.ModePerm)
+
+	if err := migrate.FromFS(ctx, session, f); err == nil {
+		t.Fatal("expected the failing statement to abort the migration")
+	}
+
+	if err := migrate.FromFS(ctx, session, f); !errors.Is(err, migrate.ErrDirty) {
+		t.Fatal("expected ErrDirty, got", err)
+	}
+
+	if err := migrate.Force(ctx, session, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	f.WriteFile("1.cql", []byte(fmt.Sprintf(insertMigrate, 1)+";"), fs.ModePerm)
+	if err := migrate.FromFS(ctx, session, f); err != nil {
+		t.Fatal("expected FromFS to recover after Force, got", err)
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	orig := migrate.LockTimeout
+	migrate.LockTimeout = 200 * time.Millisecond
+	defer func() { migrate.LockTimeout = orig }()
+
+	session := gocqlxtest.CreateSession(t)
+	defer session.Close()
+	recreateTables(t, session)
+
+	if err := session.ExecStmt(migrateSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	holdLock(t, session)
+
+	ctx := context.Background()
+	if err := migrate.FromFS(ctx, session, makeTestFS(1)); !errors.Is(err, migrate.ErrLocked) {
+		t.Fatal("expected ErrLocked, got", err)
+	}
+}
+
+// holdLock simulates another process holding the migration lock by
+// inserting the sentinel lock row directly.
+func holdLock(tb testing.TB, session gocqlx.Session) {
+	tb.Helper()
+
+	if err := session.ExecStmt(`CREATE TABLE IF NOT EXISTS gocqlx_migrate (
+		version int PRIMARY KEY,
+		name text,
+		direction text,
+		checksum blob,
+		down_checksum blob,
+		dirty boolean,
+		owner uuid,
+		acquired_at timestamp,
+		last_stmt_idx int,
+		resume_checksum blob,
+		pause_reason text,
+		timestamp timestamp
+	)`); err != nil {
+		tb.Fatal(err)
+	}
+
+	owner, err := gocql.RandomUUID()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := session.Query(
+		`INSERT INTO gocqlx_migrate (version, owner, acquired_at) VALUES (?, ?, toTimestamp(now()))`, nil,
+	).Bind(-1, owner).Exec(); err != nil {
+		tb.Fatal(err)
+	}
+}
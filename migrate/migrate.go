@@ -0,0 +1,322 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+// Package migrate provides a CQL schema migration runner.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scylladb/gocqlx/v3"
+)
+
+// CallbackEvent specifies type type of a callback event.
+type CallbackEvent int
+
+// Events passed to Callback.
+const (
+	BeforeMigration CallbackEvent = iota
+	AfterMigration
+	CallComment
+	// BeforeRollback fires before the statements of a down migration file
+	// are executed.
+	BeforeRollback
+	// AfterRollback fires after a down migration file has been executed
+	// and its entry removed from the tracking table.
+	AfterRollback
+)
+
+// CallbackFunc is the function type that will be invoked when a callback
+// event occurs.
+type CallbackFunc func(ctx context.Context, session gocqlx.Session, ev CallbackEvent, name string) error
+
+// Callback is the callback function invoked when a migration
+// callback event occurs, by default it's nil.
+var Callback CallbackFunc
+
+const migrateTable = "gocqlx_migrate"
+
+const createTableStmt = `CREATE TABLE IF NOT EXISTS ` + migrateTable + ` (
+	version int PRIMARY KEY,
+	name text,
+	direction text,
+	checksum blob,
+	down_checksum blob,
+	dirty boolean,
+	owner uuid,
+	acquired_at timestamp,
+	last_stmt_idx int,
+	resume_checksum blob,
+	pause_reason text,
+	timestamp timestamp
+)`
+
+// lockVersion is the sentinel row used to hold the distributed lock.
+// It is never treated as a migration by appliedVersions.
+const lockVersion = -1
+
+// entry describes a migration discovered on an fs.FS, pairing an up file
+// with its optional down file.
+type entry struct {
+	Version  int64
+	Name     string
+	UpFile   string
+	DownFile string
+}
+
+// appliedEntry is a row read back from the tracking table.
+type appliedEntry struct {
+	Version  int64
+	Name     string
+	Checksum []byte
+}
+
+var (
+	reLegacy = regexp.MustCompile(`^(\d+)\.cql$`)
+	reUp     = regexp.MustCompile(`^(\d+)(?:_[^.]+)?\.up\.cql$`)
+	reDown   = regexp.MustCompile(`^(\d+)(?:_[^.]+)?\.down\.cql$`)
+)
+
+// parseVersion extracts the leading version number from a migration
+// filename following either the legacy "N.cql" convention or the
+// "N_name.up.cql" / "N_name.down.cql" convention.
+func parseVersion(name string) (int64, bool) {
+	for _, re := range [...]*regexp.Regexp{reLegacy, reUp, reDown} {
+		if m := re.FindStringSubmatch(name); m != nil {
+			v, err := strconv.ParseInt(m[1], 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// listEntries walks fsys and groups files into entries by version,
+// pairing up/down files that share a version.
+func listEntries(fsys fs.FS) ([]entry, error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*entry)
+	get := func(v int64) *entry {
+		e := byVersion[v]
+		if e == nil {
+			e = &entry{Version: v}
+			byVersion[v] = e
+		}
+		return e
+	}
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		name := f.Name()
+		switch {
+		case reLegacy.MatchString(name):
+			v, _ := parseVersion(name)
+			e := get(v)
+			e.Name = name
+			e.UpFile = name
+		case reUp.MatchString(name):
+			v, _ := parseVersion(name)
+			e := get(v)
+			e.Name = name
+			e.UpFile = name
+		case reDown.MatchString(name):
+			v, _ := parseVersion(name)
+			e := get(v)
+			e.DownFile = name
+			if e.Name == "" {
+				e.Name = name
+			}
+		}
+	}
+
+	entries := make([]entry, 0, len(byVersion))
+	for _, e := range byVersion {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Version < entries[j].Version })
+	return entries, nil
+}
+
+func ensureTable(ctx context.Context, session gocqlx.Session) error {
+	return session.Query(createTableStmt, nil).WithContext(ctx).Exec()
+}
+
+func checksum(fsys fs.FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// appliedVersions returns the migrations recorded in the tracking table,
+// keyed by version. Rows still marked dirty are not considered applied:
+// a row paused by a context cancellation is resumed from its checkpoint
+// the next time FromSource runs, while any other dirty row makes
+// FromSource fail with ErrDirty until Force clears it.
+func appliedVersions(ctx context.Context, session gocqlx.Session) (map[int64]appliedEntry, error) {
+	iter := session.Query(`SELECT version, name, checksum, dirty FROM `+migrateTable, nil).WithContext(ctx).Iter()
+
+	applied := make(map[int64]appliedEntry)
+	var (
+		version int64
+		name    string
+		chk     []byte
+		dirty   bool
+	)
+	scanner := iter.Scanner()
+	for scanner.Next() {
+		if err := scanner.Scan(&version, &name, &chk, &dirty); err != nil {
+			return nil, err
+		}
+		if version < 0 || dirty {
+			continue // sentinel and in-progress rows are not applied migrations
+		}
+		applied[version] = appliedEntry{Version: version, Name: name, Checksum: chk}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// appliedVersionsDesc is like appliedVersions but returns the rows sorted
+// by descending version, the order in which Down rolls them back.
+func appliedVersionsDesc(ctx context.Context, session gocqlx.Session) ([]appliedEntry, error) {
+	byVersion, err := appliedVersions(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]appliedEntry, 0, len(byVersion))
+	for _, a := range byVersion {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version > out[j].Version })
+	return out, nil
+}
+
+func currentVersion(ctx context.Context, session gocqlx.Session) (int64, error) {
+	applied, err := appliedVersions(ctx, session)
+	if err != nil {
+		return 0, err
+	}
+	current := int64(-1)
+	for v := range applied {
+		if v > current {
+			current = v
+		}
+	}
+	return current, nil
+}
+
+// Pending returns the names of the migrations in fsys that have not been
+// applied to the database tracked by session yet, ordered by version.
+func Pending(ctx context.Context, session gocqlx.Session, fsys fs.FS) ([]string, error) {
+	return PendingFromSource(ctx, session, NewFSSource(fsys))
+}
+
+// FromFS applies all pending migrations found in fsys to the database
+// tracked by session. Migrations are applied in ascending version order;
+// already applied migrations are checked against their recorded checksum
+// to detect files that were modified after being applied. FromFS is a
+// thin adapter around FromSource for the common case of migrations
+// stored on an fs.FS.
+func FromFS(ctx context.Context, session gocqlx.Session, fsys fs.FS) error {
+	return FromSource(ctx, session, NewFSSource(fsys))
+}
+
+func execFile(ctx context.Context, session gocqlx.Session, fsys fs.FS, name string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return execReader(ctx, session, f, name)
+}
+
+func execReader(ctx context.Context, session gocqlx.Session, r io.Reader, name string) error {
+	idx := 0
+	err := SplitStatements(r, MultiStatementMaxSize, func(stmt string) error {
+		observed, err := execStatement(ctx, session, name, idx, stmt)
+		if observed {
+			idx++
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("migrate: %s: %w", name, err)
+	}
+	return nil
+}
+
+// execStatement runs a single statement emitted by SplitStatements,
+// dispatching CALL comments to Callback instead of executing them.
+// observed reports whether stmt was an actual CQL statement, as opposed
+// to a comment or blank segment, for callers that count statements.
+func execStatement(ctx context.Context, session gocqlx.Session, file string, idx int, stmt string) (observed bool, err error) {
+	if cb := IsCallback(stmt); cb != "" {
+		return false, callback(ctx, session, CallComment, cb)
+	}
+	if IsComment(stmt) || strings.TrimSpace(stmt) == "" {
+		return false, nil
+	}
+
+	start := time.Now()
+	err = session.ExecStmt(strings.TrimSpace(stmt))
+	observeStatement(ctx, file, idx, stmt, start, time.Now(), err)
+	return true, err
+}
+
+func callback(ctx context.Context, session gocqlx.Session, ev CallbackEvent, name string) error {
+	if Callback == nil {
+		return nil
+	}
+	return Callback(ctx, session, ev, name)
+}
+
+var reCall = regexp.MustCompile(`(?i)^--\s*CALL\s+(\S+?);?\s*$`)
+
+// IsCallback reports whether stmt is a "-- CALL Name;" directive and, if
+// so, returns Name. Otherwise it returns the empty string.
+func IsCallback(stmt string) string {
+	m := reCall.FindStringSubmatch(strings.TrimSpace(stmt))
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// IsComment reports whether stmt is a plain "-- ..." CQL comment. CALL
+// directives, which are also spelled as comments, are not considered
+// plain comments.
+func IsComment(stmt string) bool {
+	s := strings.TrimSpace(stmt)
+	if s == "" || !strings.HasPrefix(s, "--") {
+		return false
+	}
+	return IsCallback(stmt) == ""
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MultiStatementMaxSize is the maximum number of bytes a single statement
+// may occupy before SplitStatements gives up. It defaults to 10 MiB and
+// can be overridden to accommodate larger statements; it does not bound
+// the total size of the source, which is read in a stream.
+var MultiStatementMaxSize = 10 * 1024 * 1024
+
+type splitState int
+
+const (
+	stateDefault splitState = iota
+	stateString
+	stateLineComment
+	stateBlockComment
+)
+
+// SplitStatements reads CQL source from r and invokes fn once per
+// semicolon-terminated statement, in order, without buffering more than
+// one statement at a time. Semicolons inside single-quoted string
+// literals, "-- ..." line comments and "/* ... */" block comments are not
+// treated as statement boundaries. Reading stops at the first error
+// returned by fn, or with an error as soon as a single statement grows
+// past maxSize bytes; maxSize does not bound the total read from r.
+func SplitStatements(r io.Reader, maxSize int, fn func(stmt string) error) error {
+	br := bufio.NewReader(r)
+
+	var (
+		buf   []byte
+		state = stateDefault
+		total int
+	)
+
+	emit := func() error {
+		stmt := string(buf)
+		buf = buf[:0]
+		total = 0
+		return fn(stmt)
+	}
+
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		total++
+		if total > maxSize {
+			return fmt.Errorf("migrate: statement exceeds the %d byte limit", maxSize)
+		}
+
+		switch state {
+		case stateString:
+			buf = append(buf, b)
+			if b == '\'' {
+				state = stateDefault
+			}
+			continue
+		case stateLineComment:
+			buf = append(buf, b)
+			if b == '\n' {
+				state = stateDefault
+			}
+			continue
+		case stateBlockComment:
+			buf = append(buf, b)
+			if b == '/' && len(buf) >= 2 && buf[len(buf)-2] == '*' {
+				state = stateDefault
+			}
+			continue
+		}
+
+		switch b {
+		case '\'':
+			state = stateString
+			buf = append(buf, b)
+		case ';':
+			if err := emit(); err != nil {
+				return err
+			}
+		case '-':
+			buf = append(buf, b)
+			if len(buf) >= 2 && buf[len(buf)-2] == '-' {
+				state = stateLineComment
+			}
+		case '*':
+			buf = append(buf, b)
+			if len(buf) >= 2 && buf[len(buf)-2] == '/' {
+				state = stateBlockComment
+			}
+		default:
+			buf = append(buf, b)
+		}
+	}
+
+	if strings.TrimSpace(string(buf)) != "" {
+		return emit()
+	}
+	return nil
+}
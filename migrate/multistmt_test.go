@@ -0,0 +1,80 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+//go:build all || integration
+// +build all integration
+
+package migrate_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/scylladb/gocqlx/v3/migrate"
+)
+
+func TestSplitStatementsQuotesAndComments(t *testing.T) {
+	src := `INSERT INTO t (a) VALUES ('semi;colon');
+/* a block comment;
+spanning statements; */
+SELECT * FROM t; -- trailing comment with a ; inside
+-- CALL Foo;
+`
+
+	var stmts []string
+	err := migrate.SplitStatements(strings.NewReader(src), migrate.MultiStatementMaxSize, func(stmt string) error {
+		stmts = append(stmts, strings.TrimSpace(stmt))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"INSERT INTO t (a) VALUES ('semi;colon')",
+		"/* a block comment;\nspanning statements; */\nSELECT * FROM t",
+		"-- trailing comment with a ; inside\n-- CALL Foo;",
+	}
+	if len(stmts) != len(want) {
+		t.Fatalf("expected %d statements got %d: %#v", len(want), len(stmts), stmts)
+	}
+	for i := range want {
+		if stmts[i] != want[i] {
+			t.Errorf("statement %d = %q, want %q", i, stmts[i], want[i])
+		}
+	}
+}
+
+func TestSplitStatementsLargeFile(t *testing.T) {
+	const n = 15 * 1024 * 1024 / 32 // ~15 MiB of statements
+
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "INSERT INTO t (a) VALUES (%d);", i)
+	}
+
+	count := 0
+	err := migrate.SplitStatements(strings.NewReader(b.String()), 16*1024*1024, func(stmt string) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Fatalf("expected %d statements got %d", n, count)
+	}
+}
+
+func TestSplitStatementsMaxSize(t *testing.T) {
+	src := strings.Repeat("a", 100)
+
+	err := migrate.SplitStatements(strings.NewReader(src), 10, func(stmt string) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected an error when a statement exceeds maxSize")
+	}
+}
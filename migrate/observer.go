@@ -0,0 +1,76 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// observedStmtPreviewLen caps how much of a long statement is kept in
+// ObservedStatement.Stmt, so that observers do not end up buffering
+// entire DDL files in traces or log lines.
+const observedStmtPreviewLen = 256
+
+// ObservedStatement carries the details of a single executed CQL
+// statement, reported to Observer after it runs.
+type ObservedStatement struct {
+	// File is the migration file the statement came from.
+	File string
+	// Index is the statement's position within File, counting only
+	// actual statements (comments and CALL directives are not counted).
+	Index int
+	// Stmt is the CQL text of the statement. Text longer than
+	// observedStmtPreviewLen bytes is cut to its first
+	// observedStmtPreviewLen bytes followed by "...".
+	Stmt string
+	// Start and End bracket the execution of the statement.
+	Start, End time.Time
+	// Err is the error returned by executing the statement, if any.
+	Err error
+}
+
+// Observer is notified once per executed statement. It is intended for
+// wiring metrics, tracing or structured logging around migrations
+// without forking this package.
+type Observer interface {
+	ObserveStatement(ctx context.Context, o ObservedStatement)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(ctx context.Context, o ObservedStatement)
+
+// ObserveStatement implements Observer.
+func (f ObserverFunc) ObserveStatement(ctx context.Context, o ObservedStatement) {
+	f(ctx, o)
+}
+
+// StatementObserver, when non-nil, is notified after every statement
+// executed by FromFS and Down, once per statement. CALL comments are
+// dispatched to Callback and are not reported to StatementObserver.
+var StatementObserver Observer
+
+func observeStatement(ctx context.Context, file string, idx int, stmt string, start, end time.Time, err error) {
+	if StatementObserver == nil {
+		return
+	}
+	StatementObserver.ObserveStatement(ctx, ObservedStatement{
+		File:  file,
+		Index: idx,
+		Stmt:  previewStmt(stmt),
+		Start: start,
+		End:   end,
+		Err:   err,
+	})
+}
+
+func previewStmt(stmt string) string {
+	s := strings.TrimSpace(stmt)
+	if len(s) > observedStmtPreviewLen {
+		return s[:observedStmtPreviewLen] + "..."
+	}
+	return s
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+//go:build all || integration
+// +build all integration
+
+package migrate_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/scylladb/gocqlx/v3/gocqlxtest"
+	"github.com/scylladb/gocqlx/v3/migrate"
+)
+
+func TestStatementObserver(t *testing.T) {
+	session := gocqlxtest.CreateSession(t)
+	defer session.Close()
+	recreateTables(t, session)
+
+	if err := session.ExecStmt(migrateSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	var observed []migrate.ObservedStatement
+	migrate.StatementObserver = migrate.ObserverFunc(func(ctx context.Context, o migrate.ObservedStatement) {
+		observed = append(observed, o)
+	})
+	defer func() { migrate.StatementObserver = nil }()
+
+	// The CALL comment lives in its own file, as in TestMigrationCallback:
+	// a line comment only ends at a newline, so one sharing a file with a
+	// following statement would be folded into it by SplitStatements
+	// rather than recognized as a standalone CALL.
+	f := makeTestFS(0)
+	writeFile(f, 0, fmt.Sprintf(insertMigrate, 0)+";"+fmt.Sprintf(insertMigrate, 1)+";")
+	writeFile(f, 1, "\n-- CALL Foo;\n")
+	writeFile(f, 2, fmt.Sprintf(insertMigrate, 2)+";")
+
+	ctx := context.Background()
+	if err := migrate.FromFS(ctx, session, f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(observed) != 3 {
+		t.Fatalf("expected 3 observations (CALL comments are not observed) got %d", len(observed))
+	}
+	for i := 1; i < len(observed); i++ {
+		if observed[i].Start.Before(observed[i-1].End) {
+			t.Fatalf("observation %d started before observation %d ended", i, i-1)
+		}
+	}
+	for _, o := range observed {
+		if o.Err != nil {
+			t.Fatalf("unexpected error on %q: %v", o.Stmt, o.Err)
+		}
+	}
+}
+
+func TestStatementObserverRecordsErrors(t *testing.T) {
+	session := gocqlxtest.CreateSession(t)
+	defer session.Close()
+	recreateTables(t, session)
+
+	if err := session.ExecStmt(migrateSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	var observed []migrate.ObservedStatement
+	migrate.StatementObserver = migrate.ObserverFunc(func(ctx context.Context, o migrate.ObservedStatement) {
+		observed = append(observed, o)
+	})
+	defer func() { migrate.StatementObserver = nil }()
+
+	f := makeTestFS(0)
+	writeFile(f, 0, "SELECT * FROM bla;")
+
+	ctx := context.Background()
+	if err := migrate.FromFS(ctx, session, f); err == nil {
+		t.Fatal("expected the tampered statement to fail")
+	}
+
+	if len(observed) != 1 {
+		t.Fatalf("expected 1 observation got %d", len(observed))
+	}
+	if observed[0].Err == nil || !strings.Contains(observed[0].Err.Error(), "bla") {
+		t.Fatalf("expected observation to carry the execution error, got %v", observed[0].Err)
+	}
+}
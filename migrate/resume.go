@@ -0,0 +1,157 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/scylladb/gocqlx/v3"
+)
+
+// ResumePolicy controls how a migration file that was interrupted
+// mid-way through a previous run is validated before FromFS resumes it.
+type ResumePolicy int
+
+const (
+	// ResumeStrict requires the file checksum recorded when the
+	// migration was paused to still match before resuming. This is the
+	// default.
+	ResumeStrict ResumePolicy = iota
+	// ResumeAllow skips that check, trusting the caller to only have
+	// edited statements after the last executed one.
+	ResumeAllow
+)
+
+// Resume controls how FromFS and FromSource resume a partially applied
+// migration file. The default is ResumeStrict.
+var Resume = ResumeStrict
+
+// noResume marks a version that has no in-progress checkpoint.
+const noResume = -1
+
+// resumeState returns the index of the last successfully executed
+// statement for version and the checksum recorded at the time it was
+// paused, or (noResume, nil, nil) if there is no in-progress checkpoint.
+func resumeState(ctx context.Context, session gocqlx.Session, version int64) (int, []byte, error) {
+	var (
+		lastStmtIdx *int
+		resumeSum   []byte
+	)
+	q := session.Query(`SELECT last_stmt_idx, resume_checksum FROM `+migrateTable+` WHERE version = ?`, nil).
+		WithContext(ctx).Bind(version)
+	if err := q.Scan(&lastStmtIdx, &resumeSum); err != nil {
+		return noResume, nil, err
+	}
+	if lastStmtIdx == nil {
+		return noResume, nil, nil
+	}
+	return *lastStmtIdx, resumeSum, nil
+}
+
+// checkpoint records that the statement at idx in the migration at
+// version has executed successfully, so that a later run can resume
+// after it instead of re-running it.
+func checkpoint(ctx context.Context, session gocqlx.Session, version int64, idx int, sum []byte) error {
+	stmt := `UPDATE ` + migrateTable + ` SET last_stmt_idx = ?, resume_checksum = ? WHERE version = ?`
+	return session.Query(stmt, nil).WithContext(ctx).Bind(idx, sum, version).Exec()
+}
+
+// clearCheckpoint removes the in-progress checkpoint for version once its
+// migration has finished running, successfully or not.
+func clearCheckpoint(ctx context.Context, session gocqlx.Session, version int64) error {
+	stmt := `UPDATE ` + migrateTable + ` SET last_stmt_idx = null, resume_checksum = null, pause_reason = null WHERE version = ?`
+	return session.Query(stmt, nil).WithContext(ctx).Bind(version).Exec()
+}
+
+// markPaused records that version stopped because its context was
+// cancelled between statements, not because a statement failed, so that
+// checkNotDirty lets a later run resume it automatically instead of
+// demanding Force.
+func markPaused(ctx context.Context, session gocqlx.Session, version int64) {
+	stmt := `UPDATE ` + migrateTable + ` SET pause_reason = ? WHERE version = ?`
+	// Best effort: if this write fails the version is simply treated as
+	// dirty and requires Force, which is always safe.
+	_ = session.Query(stmt, nil).WithContext(ctx).Bind(pauseReasonCancelled, version).Exec()
+}
+
+// execResumable runs the statements read from src's entry e, skipping
+// any already recorded as executed in a previous, interrupted run, and
+// checkpointing progress after every statement so that a cancellation
+// can be resumed later without re-running completed statements.
+func execResumable(ctx context.Context, session gocqlx.Session, src Source, e Entry, sum []byte) error {
+	resumeIdx, resumeSum, err := resumeState(ctx, session, e.Version)
+	if err != nil {
+		return err
+	}
+	if resumeIdx != noResume && Resume == ResumeStrict && !bytes.Equal(resumeSum, sum) {
+		return fmt.Errorf("migrate: %s changed since it was paused, re-run with migrate.Resume = migrate.ResumeAllow or restart the migration", e.Name)
+	}
+
+	r, err := src.Open(e.Name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// idx counts only actual statements, like execReader's idx, so it is
+	// comparable between the up and down paths regardless of leading
+	// comments, and so the value persisted by checkpoint and compared
+	// against resumeIdx means the same thing on every run of this file.
+	idx := 0
+	paused := false
+	err = SplitStatements(r, MultiStatementMaxSize, func(stmt string) error {
+		counts := IsCallback(stmt) == "" && !IsComment(stmt) && strings.TrimSpace(stmt) != ""
+
+		if idx <= resumeIdx {
+			if counts {
+				idx++
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			markPaused(context.Background(), session, e.Version)
+			paused = true
+			return ctx.Err()
+		default:
+		}
+
+		observed, execErr := execStatement(ctx, session, e.Name, idx, stmt)
+		if execErr != nil {
+			return execErr
+		}
+		// Checkpoint with a background context: the statement above has
+		// already run, so a cancellation racing with this write must not
+		// stop the committed index from persisting, or the row is left
+		// dirty with no pause_reason and the next run sees ErrDirty
+		// instead of resuming.
+		if observed {
+			if err := checkpoint(context.Background(), session, e.Version, idx, sum); err != nil {
+				return err
+			}
+			idx++
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			markPaused(context.Background(), session, e.Version)
+			paused = true
+			return ctxErr
+		}
+		return nil
+	})
+	if err != nil {
+		if !paused {
+			// A failed run has finished: drop its checkpoint, including a
+			// pause_reason left by an earlier pause, so that checkNotDirty
+			// reports the row as dirty instead of resuming past the failure.
+			_ = clearCheckpoint(context.Background(), session, e.Version)
+		}
+		return fmt.Errorf("migrate: %s: %w", e.Name, err)
+	}
+	return clearCheckpoint(ctx, session, e.Version)
+}
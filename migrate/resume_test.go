@@ -0,0 +1,70 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+//go:build all || integration
+// +build all integration
+
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/scylladb/gocqlx/v3/gocqlxtest"
+	"github.com/scylladb/gocqlx/v3/migrate"
+)
+
+func TestResumeAfterCancellation(t *testing.T) {
+	session := gocqlxtest.CreateSession(t)
+	defer session.Close()
+	recreateTables(t, session)
+
+	if err := session.ExecStmt(migrateSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	var executed []int
+	defer func() { migrate.StatementObserver = nil }()
+
+	f := makeTestFS(0)
+	var stmts string
+	for i := 0; i < 5; i++ {
+		stmts += fmt.Sprintf(insertMigrate, i) + ";"
+	}
+	f.WriteFile("0.cql", []byte(stmts), fs.ModePerm)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelAfter := 2
+	migrate.StatementObserver = migrate.ObserverFunc(func(innerCtx context.Context, o migrate.ObservedStatement) {
+		executed = append(executed, o.Index)
+		if len(executed) == cancelAfter {
+			cancel()
+		}
+	})
+
+	if err := migrate.FromFS(ctx, session, f); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a context.Canceled error, got %v", err)
+	}
+	if len(executed) != cancelAfter {
+		t.Fatalf("expected exactly %d statements to run before cancellation, got %d", cancelAfter, len(executed))
+	}
+
+	executed = nil
+	migrate.StatementObserver = migrate.ObserverFunc(func(ctx context.Context, o migrate.ObservedStatement) {
+		executed = append(executed, o.Index)
+	})
+
+	if err := migrate.FromFS(context.Background(), session, f); err != nil {
+		t.Fatal("expected the resumed run to succeed, got", err)
+	}
+	if len(executed) != 3 {
+		t.Fatalf("expected statements 3-5 to run exactly once, got %d: %v", len(executed), executed)
+	}
+	if c := countMigrations(t, session); c != 5 {
+		t.Fatal("expected 5 migrations got", c)
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/scylladb/gocqlx/v3"
+)
+
+// Entry describes a single migration as reported by a Source.
+type Entry struct {
+	Name    string
+	Version int64
+}
+
+// Source abstracts where migration files come from, so that FromSource
+// does not need to know whether migrations live on disk, in memory, in
+// object storage or behind an HTTP endpoint.
+type Source interface {
+	// List returns every migration entry the source knows about, ordered
+	// by ascending version.
+	List() ([]Entry, error)
+	// Open returns the contents of the named entry. The caller must
+	// close the returned reader.
+	Open(name string) (io.ReadCloser, error)
+	// Checksum returns a stable checksum of the named entry's contents.
+	// Implementations that can compute it without reading the whole
+	// entry (e.g. from a manifest) should do so.
+	Checksum(name string) ([]byte, error)
+}
+
+// FSSource adapts an fs.FS to Source using the "N.cql" / "N_name.up.cql"
+// naming convention also understood by Down and Goto.
+type FSSource struct {
+	fsys fs.FS
+}
+
+// NewFSSource returns a Source backed by fsys.
+func NewFSSource(fsys fs.FS) *FSSource {
+	return &FSSource{fsys: fsys}
+}
+
+// List implements Source.
+func (s *FSSource) List() ([]Entry, error) {
+	entries, err := listEntries(s.fsys)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if e.UpFile == "" {
+			continue
+		}
+		out = append(out, Entry{Name: e.UpFile, Version: e.Version})
+	}
+	return out, nil
+}
+
+// Open implements Source.
+func (s *FSSource) Open(name string) (io.ReadCloser, error) {
+	return s.fsys.Open(name)
+}
+
+// Checksum implements Source.
+func (s *FSSource) Checksum(name string) ([]byte, error) {
+	return checksum(s.fsys, name)
+}
+
+// downChecksumFor implements downChecksumSource, letting Down's tamper
+// check work for migrations applied through FromFS even though FromSource
+// and the Source interface have no notion of a down file.
+func (s *FSSource) downChecksumFor(name string) ([]byte, bool, error) {
+	entries, err := listEntries(s.fsys)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, e := range entries {
+		if e.UpFile != name {
+			continue
+		}
+		if e.DownFile == "" {
+			return nil, false, nil
+		}
+		sum, err := checksum(s.fsys, e.DownFile)
+		return sum, true, err
+	}
+	return nil, false, nil
+}
+
+// StaticSource is an in-memory Source, useful in tests and for embedding
+// migrations (e.g. with go:embed) without an fs.FS. Entry names follow
+// the same naming convention as FSSource.
+type StaticSource struct {
+	entries []Entry
+	bodies  map[string][]byte
+}
+
+// NewStaticSource builds a StaticSource from name to file-body pairs.
+// Versions are parsed from each name using the same convention as
+// FSSource, and, as in FSSource.List, down files are not listed.
+func NewStaticSource(files map[string]string) (*StaticSource, error) {
+	s := &StaticSource{bodies: make(map[string][]byte, len(files))}
+	for name, body := range files {
+		if reDown.MatchString(name) {
+			continue
+		}
+		v, ok := parseVersion(name)
+		if !ok {
+			return nil, fmt.Errorf("migrate: %s does not match a known migration filename pattern", name)
+		}
+		s.entries = append(s.entries, Entry{Name: name, Version: v})
+		s.bodies[name] = []byte(body)
+	}
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].Version < s.entries[j].Version })
+	return s, nil
+}
+
+// List implements Source.
+func (s *StaticSource) List() ([]Entry, error) {
+	out := make([]Entry, len(s.entries))
+	copy(out, s.entries)
+	return out, nil
+}
+
+// Open implements Source.
+func (s *StaticSource) Open(name string) (io.ReadCloser, error) {
+	b, ok := s.bodies[name]
+	if !ok {
+		return nil, fmt.Errorf("migrate: unknown entry %s", name)
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// Checksum implements Source.
+func (s *StaticSource) Checksum(name string) ([]byte, error) {
+	b, ok := s.bodies[name]
+	if !ok {
+		return nil, fmt.Errorf("migrate: unknown entry %s", name)
+	}
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
+
+// HTTPSource fetches migrations over HTTP. It expects an index manifest
+// at Base+"/index.json", a JSON array of Entry, and the body of each
+// entry served at Base+"/"+Entry.Name.
+type HTTPSource struct {
+	Base   string
+	Client *http.Client
+}
+
+// NewHTTPSource returns a Source backed by an HTTP manifest served from
+// base. If client is nil, http.DefaultClient is used.
+func NewHTTPSource(base string, client *http.Client) *HTTPSource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPSource{Base: strings.TrimRight(base, "/"), Client: client}
+}
+
+// List implements Source.
+func (s *HTTPSource) List() ([]Entry, error) {
+	resp, err := s.Client.Get(s.Base + "/index.json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("migrate: fetching manifest: unexpected status %s", resp.Status)
+	}
+
+	var entries []Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("migrate: decoding manifest: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Version < entries[j].Version })
+	return entries, nil
+}
+
+// Open implements Source.
+func (s *HTTPSource) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.Client.Get(s.Base + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("migrate: fetching %s: unexpected status %s", name, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Checksum implements Source.
+func (s *HTTPSource) Checksum(name string) ([]byte, error) {
+	r, err := s.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// PendingFromSource is like Pending but reads migrations from src instead
+// of an fs.FS.
+func PendingFromSource(ctx context.Context, session gocqlx.Session, src Source) ([]string, error) {
+	if err := ensureTable(ctx, session); err != nil {
+		return nil, err
+	}
+	entries, err := src.List()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for _, e := range entries {
+		if _, ok := applied[e.Version]; !ok {
+			pending = append(pending, e.Name)
+		}
+	}
+	return pending, nil
+}
+
+// downChecksumSource is an optional capability implemented by sources
+// that also know about paired down files, currently only FSSource. A
+// Source that does not implement it (StaticSource, HTTPSource) has no
+// notion of a down file, so migrations applied through it are not
+// covered by Down's tamper check.
+type downChecksumSource interface {
+	downChecksumFor(name string) (sum []byte, ok bool, err error)
+}
+
+// FromSource applies all pending migrations from src to the database
+// tracked by session, in ascending version order. FromFS is a thin
+// adapter around FromSource for the common case of migrations stored on
+// an fs.FS.
+func FromSource(ctx context.Context, session gocqlx.Session, src Source) error {
+	return withLock(ctx, session, func() error {
+		entries, err := src.List()
+		if err != nil {
+			return err
+		}
+		applied, err := appliedVersions(ctx, session)
+		if err != nil {
+			return err
+		}
+
+		if len(applied) > len(entries) {
+			return fmt.Errorf("migrate: database is ahead of the migration source, applied %d migrations, found %d", len(applied), len(entries))
+		}
+
+		for _, e := range entries {
+			if a, ok := applied[e.Version]; ok {
+				if a.Checksum != nil {
+					sum, err := src.Checksum(e.Name)
+					if err != nil {
+						return err
+					}
+					if !bytes.Equal(sum, a.Checksum) {
+						return fmt.Errorf("migrate: migration %s has been tampered with", e.Name)
+					}
+				}
+				continue
+			}
+			if err := applyUpFromSource(ctx, session, src, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func applyUpFromSource(ctx context.Context, session gocqlx.Session, src Source, e Entry) error {
+	if err := callback(ctx, session, BeforeMigration, e.Name); err != nil {
+		return err
+	}
+
+	sum, err := src.Checksum(e.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := markDirty(ctx, session, e.Version, e.Name); err != nil {
+		return err
+	}
+
+	if err := execResumable(ctx, session, src, e, sum); err != nil {
+		return err
+	}
+
+	var downSum []byte
+	if dcs, ok := src.(downChecksumSource); ok {
+		downSum, _, err = dcs.downChecksumFor(e.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt := `INSERT INTO ` + migrateTable + ` (version, name, direction, checksum, down_checksum, dirty, timestamp) VALUES (?, ?, 'up', ?, ?, false, toTimestamp(now()))`
+	if err := session.Query(stmt, nil).WithContext(ctx).Bind(e.Version, e.Name, sum, downSum).Exec(); err != nil {
+		return err
+	}
+
+	return callback(ctx, session, AfterMigration, e.Name)
+}
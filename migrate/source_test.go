@@ -0,0 +1,111 @@
+// Copyright (C) 2017 ScyllaDB
+// Use of this source code is governed by a ALv2-style
+// license that can be found in the LICENSE file.
+
+//go:build all || integration
+// +build all integration
+
+package migrate_test
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/psanford/memfs"
+
+	"github.com/scylladb/gocqlx/v3/migrate"
+)
+
+// sourceConformance exercises ordering, checksum stability and error
+// semantics that every Source implementation must satisfy.
+func sourceConformance(t *testing.T, src migrate.Source) {
+	t.Helper()
+
+	t.Run("ordering", func(t *testing.T) {
+		entries, err := src.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(entries); i++ {
+			if entries[i-1].Version >= entries[i].Version {
+				t.Fatalf("entries not in ascending version order: %+v", entries)
+			}
+		}
+	})
+
+	t.Run("checksum is stable", func(t *testing.T) {
+		entries, err := src.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			t.Fatal("expected at least one entry")
+		}
+		name := entries[0].Name
+
+		a, err := src.Checksum(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := src.Checksum(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(a, b) {
+			t.Fatalf("Checksum(%s) is not stable across calls", name)
+		}
+
+		r, err := src.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(body) == 0 {
+			t.Fatalf("Open(%s) returned no content", name)
+		}
+	})
+
+	t.Run("unknown entry errors", func(t *testing.T) {
+		if _, err := src.Open("does-not-exist.cql"); err == nil {
+			t.Fatal("expected Open of an unknown entry to fail")
+		}
+		if _, err := src.Checksum("does-not-exist.cql"); err == nil {
+			t.Fatal("expected Checksum of an unknown entry to fail")
+		}
+	})
+}
+
+func TestFSSourceConformance(t *testing.T) {
+	f := memfs.New()
+	f.WriteFile("0.cql", []byte("SELECT 1;"), fs.ModePerm)
+	f.WriteFile("1.cql", []byte("SELECT 2;"), fs.ModePerm)
+
+	sourceConformance(t, migrate.NewFSSource(f))
+}
+
+func TestStaticSourceConformance(t *testing.T) {
+	src, err := migrate.NewStaticSource(map[string]string{
+		"0.cql": "SELECT 1;",
+		"1.cql": "SELECT 2;",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sourceConformance(t, src)
+}
+
+func TestStaticSourceRejectsUnknownFilenames(t *testing.T) {
+	_, err := migrate.NewStaticSource(map[string]string{
+		"not-a-migration.txt": "SELECT 1;",
+	})
+	if err == nil {
+		t.Fatal("expected an error for a name that doesn't match the migration filename pattern")
+	}
+}